@@ -0,0 +1,203 @@
+package graph
+
+import (
+	"fmt"
+
+	"github.com/sath33sh/infra/db"
+)
+
+// Row shape used to decode a single GROUP BY bucket and its count.
+type bucketCountRow struct {
+	Bucket string `json:"bucket"`
+	Count  int    `json:"count"`
+}
+
+type bucketCountResult struct {
+	Results []bucketCountRow
+}
+
+func (qr *bucketCountResult) GetRowPtr(index int) interface{} {
+	if index < len(qr.Results) {
+		return &qr.Results[index]
+	} else if index == len(qr.Results) {
+		qr.Results = append(qr.Results, bucketCountRow{})
+		return &qr.Results[index]
+	} else {
+		return nil
+	}
+}
+
+// N1QL predicate selecting arcs adjacent to node in the given direction.
+func adjacencyClause(node *Node, dir Direction) string {
+	switch dir {
+	case Outgoing:
+		return fmt.Sprintf("tail.type=\"%s\" AND tail.id=\"%s\"", node.Type, node.Id)
+	case Incoming:
+		return fmt.Sprintf("head.type=\"%s\" AND head.id=\"%s\"", node.Type, node.Id)
+	default: // Both
+		return fmt.Sprintf("(tail.type=\"%s\" AND tail.id=\"%s\") OR (head.type=\"%s\" AND head.id=\"%s\")",
+			node.Type, node.Id, node.Type, node.Id)
+	}
+}
+
+// Run a GROUP BY count query and collect it into a bucket -> count map. queryStmt must
+// project the group key as "bucket" and the count as "count".
+func execBucketCount(queryStmt string) (map[string]int, error) {
+	var qr bucketCountResult
+	size, err := db.ExecPagedQuery(db.DEFAULT_BUCKET, &qr, queryStmt, db.QUERY_LIMIT_MAX, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int, size)
+	for _, row := range qr.Results[:size] {
+		counts[row.Bucket] = row.Count
+	}
+
+	return counts, nil
+}
+
+// CountByField groups the arcs adjacent to node in direction dir that hold verb r by
+// groupBy, an N1QL field path such as "tail.type", "head.type", or any other arc field.
+// groupBy is interpolated directly into the query, so callers must pass a fixed field
+// path, never unsanitized user input. opts controls whether archived or expired arcs are
+// counted, same as every other query in this package. It issues a single N1QL statement.
+func CountByField(node *Node, dir Direction, r RelationVerb, groupBy string, opts QueryOptions) (map[string]int, error) {
+	queryStmt := fmt.Sprintf("SELECT %s AS bucket, COUNT(*) AS count FROM `%s` WHERE type=\"%s\" AND (%s) AND %s%s GROUP BY %s",
+		groupBy, db.BucketName(db.DEFAULT_BUCKET), OBJ_ARC, adjacencyClause(node, dir), activeClause(fmt.Sprintf("relation.%s", r)), opts.filterClause(), groupBy)
+
+	return execBucketCount(queryStmt)
+}
+
+// IndegreeByType returns, for each node type, the number of tails of that type holding
+// verb r towards head. It issues a single GROUP BY query rather than one Indegree call
+// per candidate type.
+func IndegreeByType(head *Node, r RelationVerb, opts QueryOptions) (map[db.ObjType]int, error) {
+	raw, err := CountByField(head, Incoming, r, "tail.type", opts)
+	if err != nil {
+		return nil, err
+	}
+
+	byType := make(map[db.ObjType]int, len(raw))
+	for k, v := range raw {
+		byType[db.ObjType(k)] = v
+	}
+	return byType, nil
+}
+
+// OutdegreeByType returns, for each node type, the number of heads of that type reached
+// from tail via verb r. It issues a single GROUP BY query rather than one Outdegree call
+// per candidate type.
+func OutdegreeByType(tail *Node, r RelationVerb, opts QueryOptions) (map[db.ObjType]int, error) {
+	raw, err := CountByField(tail, Outgoing, r, "head.type", opts)
+	if err != nil {
+		return nil, err
+	}
+
+	byType := make(map[db.ObjType]int, len(raw))
+	for k, v := range raw {
+		byType[db.ObjType(k)] = v
+	}
+	return byType, nil
+}
+
+// CountByVerb returns, for each relation verb, the number of arcs adjacent to node in
+// direction dir that hold it. Verbs are discovered via UNNEST over OBJECT_PAIRS(relation)
+// rather than being enumerated one at a time, so a single N1QL statement covers every
+// verb ever seen on this node's arcs, known or not. opts controls whether archived or
+// expired arcs are counted, same as every other query in this package.
+func CountByVerb(node *Node, dir Direction, opts QueryOptions) (map[RelationVerb]int, error) {
+	queryStmt := fmt.Sprintf("SELECT pair.name AS bucket, COUNT(*) AS count FROM `%s` AS arc "+
+		"UNNEST OBJECT_PAIRS(arc.relation) AS pair WHERE arc.type=\"%s\" AND (%s) AND %s%s GROUP BY pair.name",
+		db.BucketName(db.DEFAULT_BUCKET), OBJ_ARC, adjacencyClauseAliased(node, dir, "arc"), activeClause("pair.val"), opts.filterClauseAliased("arc"))
+
+	raw, err := execBucketCount(queryStmt)
+	if err != nil {
+		return nil, err
+	}
+
+	byVerb := make(map[RelationVerb]int, len(raw))
+	for k, v := range raw {
+		byVerb[RelationVerb(k)] = v
+	}
+	return byVerb, nil
+}
+
+// Same as adjacencyClause, but with fields qualified by alias (needed once the arc bucket
+// is aliased, as CountByVerb does for its UNNEST).
+func adjacencyClauseAliased(node *Node, dir Direction, alias string) string {
+	switch dir {
+	case Outgoing:
+		return fmt.Sprintf("%s.tail.type=\"%s\" AND %s.tail.id=\"%s\"", alias, node.Type, alias, node.Id)
+	case Incoming:
+		return fmt.Sprintf("%s.head.type=\"%s\" AND %s.head.id=\"%s\"", alias, node.Type, alias, node.Id)
+	default: // Both
+		return fmt.Sprintf("(%s.tail.type=\"%s\" AND %s.tail.id=\"%s\") OR (%s.head.type=\"%s\" AND %s.head.id=\"%s\")",
+			alias, node.Type, alias, node.Id, alias, node.Type, alias, node.Id)
+	}
+}
+
+// Node paired with its own degree, used to rank neighbors by how well-connected they are.
+type NodeCount struct {
+	Node
+	Count int `json:"count"`
+}
+
+type nodeCountResult struct {
+	Results []NodeCount
+}
+
+func (qr *nodeCountResult) GetRowPtr(index int) interface{} {
+	if index < len(qr.Results) {
+		return &qr.Results[index]
+	} else if index == len(qr.Results) {
+		qr.Results = append(qr.Results, NodeCount{})
+		return &qr.Results[index]
+	} else {
+		return nil
+	}
+}
+
+// TopTails returns the n tails of head via verb r with the highest outdegree of their own,
+// i.e. the most well-connected neighbors rather than an arbitrary n of them. Each neighbor's
+// degree is computed with a correlated subquery so the whole call is one N1QL statement.
+// opts controls whether archived or expired arcs are eligible, same as every other query in
+// this package.
+func TopTails(head *Node, r RelationVerb, n int, opts QueryOptions) ([]NodeCount, error) {
+	bucket := db.BucketName(db.DEFAULT_BUCKET)
+	queryStmt := fmt.Sprintf("SELECT tail.*, "+
+		"(SELECT RAW COUNT(*) FROM `%s` AS a2 WHERE a2.type=\"%s\" AND a2.tail.type=tail.type AND a2.tail.id=tail.id)[0] AS count "+
+		"FROM `%s` WHERE type=\"%s\" AND head.type=\"%s\" AND head.id=\"%s\" AND %s%s "+
+		"ORDER BY count DESC",
+		bucket, OBJ_ARC, bucket, OBJ_ARC, head.Type, head.Id, activeClause(fmt.Sprintf("relation.%s", r)), opts.filterClause())
+
+	var qr nodeCountResult
+	size, err := db.ExecPagedQuery(db.DEFAULT_BUCKET, &qr, queryStmt, n, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return qr.Results[:size], nil
+}
+
+// TopHeads returns the n heads from tail via verb r with the highest indegree of their own,
+// i.e. the most well-connected neighbors rather than an arbitrary n of them. Each neighbor's
+// degree is computed with a correlated subquery so the whole call is one N1QL statement.
+// opts controls whether archived or expired arcs are eligible, same as every other query in
+// this package.
+func TopHeads(tail *Node, r RelationVerb, n int, opts QueryOptions) ([]NodeCount, error) {
+	bucket := db.BucketName(db.DEFAULT_BUCKET)
+	queryStmt := fmt.Sprintf("SELECT head.*, "+
+		"(SELECT RAW COUNT(*) FROM `%s` AS a2 WHERE a2.type=\"%s\" AND a2.head.type=head.type AND a2.head.id=head.id)[0] AS count "+
+		"FROM `%s` WHERE type=\"%s\" AND tail.type=\"%s\" AND tail.id=\"%s\" AND %s%s "+
+		"ORDER BY count DESC",
+		bucket, OBJ_ARC, bucket, OBJ_ARC, tail.Type, tail.Id, activeClause(fmt.Sprintf("relation.%s", r)), opts.filterClause())
+
+	var qr nodeCountResult
+	size, err := db.ExecPagedQuery(db.DEFAULT_BUCKET, &qr, queryStmt, n, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return qr.Results[:size], nil
+}
@@ -0,0 +1,113 @@
+package graph
+
+import (
+	"time"
+
+	"github.com/sath33sh/infra/db"
+)
+
+// DeleteArc permanently removes the arc between tail and head, along with every relation
+// verb it carries. Use ArchiveArc instead if the arc should stop appearing in traversal
+// but still be recoverable. from is an optional originator forwarded to registered event
+// sinks; pass none when the caller has no notion of one, or a single Node to attribute it.
+func DeleteArc(tail, head *Node, from ...*Node) error {
+	a := Arc{Tail: Node{Type: tail.Type, Id: tail.Id}, Head: Node{Type: head.Type, Id: head.Id}}
+	if err := db.Get(&a); err != nil {
+		return err
+	}
+
+	if err := db.Delete(&a); err != nil {
+		return err
+	}
+
+	a.From = nil
+	if len(from) > 0 {
+		a.From = from[0]
+	}
+	notifyArcDeleted(a)
+
+	return nil
+}
+
+// RemoveRelation deletes the given verbs from the arc between tail and head. If no verbs
+// remain afterwards, the arc itself is deleted rather than left behind as an empty husk.
+func RemoveRelation(tail, head *Node, verbs ...RelationVerb) (err error) {
+	// Perform RWM.
+
+	// Read.
+	var lock db.Lock
+	la := Arc{Tail: Node{Type: tail.Type, Id: tail.Id}, Head: Node{Type: head.Type, Id: head.Id}}
+	if lock, err = db.GetLock(&la); err != nil {
+		return err
+	}
+	before := la
+	before.Relation = make(Relation, len(la.Relation))
+	for verb, val := range la.Relation {
+		before.Relation[verb] = val
+	}
+
+	// Update.
+	la.Tail = *tail
+	la.Head = *head
+	la.From = nil
+	for _, verb := range verbs {
+		delete(la.Relation, verb)
+	}
+
+	if len(la.Relation) == 0 {
+		// No verbs remain: delete the already-loaded, already-locked arc directly
+		// rather than writing back an empty husk and then re-reading it to delete.
+		if err = db.Delete(&la); err != nil {
+			return err
+		}
+
+		notifyArcDeleted(la)
+
+		return nil
+	}
+
+	// Write.
+	if err = db.WriteUnlock(&la, lock, 0); err != nil {
+		return err
+	}
+
+	notifyRelationChanged(before, la)
+
+	return nil
+}
+
+// ArchiveArc soft-deletes the arc between tail and head: it stays in the database but is
+// excluded from QueryTails, QueryHeads, Indegree, Outdegree, ForEachTail and ForEachHead
+// unless the caller passes QueryOptions{IncludeArchived: true}. from is an optional
+// originator forwarded to registered event sinks; pass none when the caller has no notion
+// of one, or a single Node to attribute it. Sinks see this as an OnArcDeleted event: as far
+// as traversal is concerned, an archived arc is gone, whether or not it can be recovered.
+func ArchiveArc(tail, head *Node, from ...*Node) (err error) {
+	// Perform RWM.
+
+	// Read.
+	var lock db.Lock
+	la := Arc{Tail: Node{Type: tail.Type, Id: tail.Id}, Head: Node{Type: head.Type, Id: head.Id}}
+	if lock, err = db.GetLock(&la); err != nil {
+		return err
+	}
+
+	// Update.
+	la.Tail = *tail
+	la.Head = *head
+	now := time.Now()
+	la.ArchivedAt = &now
+	la.From = nil
+	if len(from) > 0 {
+		la.From = from[0]
+	}
+
+	// Write.
+	if err = db.WriteUnlock(&la, lock, 0); err != nil {
+		return err
+	}
+
+	notifyArcDeleted(la)
+
+	return nil
+}
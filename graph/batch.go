@@ -0,0 +1,198 @@
+package graph
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sath33sh/infra/db"
+)
+
+// Default number of arcs per batch when BatchOptions.BatchSize is unset.
+const defaultBatchSize = 500
+
+// Bound on concurrent goroutines used to apply a batch when the db layer has no bulk
+// primitive for the operation and we fall back to per-arc calls.
+const maxBatchWorkers = 16
+
+// Options controlling batch arc mutations.
+type BatchOptions struct {
+	BatchSize int  // Arcs per chunk sent to the db layer. Defaults to defaultBatchSize when <= 0.
+	DryRun    bool // Report what would change without writing anything.
+}
+
+// One failed item in a batch mutation, keyed by its index in the input slice.
+type BatchFailure struct {
+	Index int   `json:"index"`
+	Err   error `json:"error"`
+}
+
+// Outcome of a batch arc mutation.
+type BatchResult struct {
+	Succeeded []int          `json:"succeeded,omitempty"` // Indices into the input slice that were applied.
+	Failed    []BatchFailure `json:"failed,omitempty"`    // Indices into the input slice that failed, with their error.
+}
+
+func (opts BatchOptions) batchSize() int {
+	if opts.BatchSize <= 0 {
+		return defaultBatchSize
+	}
+	return opts.BatchSize
+}
+
+// Run fn(i) for every index in [0, n), at most maxBatchWorkers at a time, and collect a
+// BatchResult from the per-index errors.
+func runBatch(n int, fn func(i int) error) BatchResult {
+	var result BatchResult
+	var mu sync.Mutex
+
+	workers := maxBatchWorkers
+	if n < workers {
+		workers = n
+	}
+
+	work := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				if err := fn(i); err != nil {
+					mu.Lock()
+					result.Failed = append(result.Failed, BatchFailure{Index: i, Err: err})
+					mu.Unlock()
+				} else {
+					mu.Lock()
+					result.Succeeded = append(result.Succeeded, i)
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < n; i++ {
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+
+	return result
+}
+
+// CreateArcs creates every arc in arcs, chunking the work into batches of
+// opts.BatchSize (default 500) grouped by bucket. Couchbase's Go SDK exposes bulk
+// upsert per bucket, not across buckets, hence the grouping; today every Arc maps to
+// db.DEFAULT_BUCKET (see Arc.GetMeta), but grouping keeps this correct if that ever
+// changes. There is no bulk-upsert primitive in the db layer yet, so each batch is
+// applied with a bounded worker pool instead of a single round-trip; swap that call
+// out once one lands. With opts.DryRun, no writes are issued and every arc is reported
+// as it would succeed.
+func CreateArcs(arcs []Arc, opts BatchOptions) (BatchResult, error) {
+	var result BatchResult
+
+	byBucket := map[interface{}][]int{}
+	for i, a := range arcs {
+		bucket := a.GetMeta().Bucket
+		byBucket[bucket] = append(byBucket[bucket], i)
+	}
+
+	for _, indices := range byBucket {
+		for start := 0; start < len(indices); start += opts.batchSize() {
+			end := start + opts.batchSize()
+			if end > len(indices) {
+				end = len(indices)
+			}
+			batch := indices[start:end]
+
+			var batchResult BatchResult
+			if opts.DryRun {
+				batchResult.Succeeded = batch
+			} else {
+				batchResult = runBatch(len(batch), func(i int) error {
+					a := &arcs[batch[i]]
+					if a.CreatedAt.IsZero() {
+						a.CreatedAt = time.Now()
+					}
+					if err := db.Upsert(a, 0); err != nil {
+						return err
+					}
+					notifyArcCreated(*a)
+					return nil
+				})
+				// runBatch reports positions within batch; translate back to positions within arcs.
+				batchResult = translateBatchResult(batchResult, batch)
+			}
+
+			result.Succeeded = append(result.Succeeded, batchResult.Succeeded...)
+			result.Failed = append(result.Failed, batchResult.Failed...)
+		}
+	}
+
+	return result, nil
+}
+
+// Translate a BatchResult whose indices are positions within batch into one whose
+// indices are the original values held at those positions.
+func translateBatchResult(r BatchResult, batch []int) BatchResult {
+	out := BatchResult{
+		Succeeded: make([]int, len(r.Succeeded)),
+		Failed:    make([]BatchFailure, len(r.Failed)),
+	}
+	for i, pos := range r.Succeeded {
+		out.Succeeded[i] = batch[pos]
+	}
+	for i, f := range r.Failed {
+		out.Failed[i] = BatchFailure{Index: batch[f.Index], Err: f.Err}
+	}
+	return out
+}
+
+// One relation update as part of a batch, as passed to UpdateRelations.
+type RelationUpdate struct {
+	Tail     Node
+	Head     Node
+	Relation Relation
+	From     *Node // Originator forwarded to registered event sinks. Nil if unknown.
+}
+
+// UpdateRelations applies every update in updates, chunking the work into batches of
+// opts.BatchSize (default 500). Each update still goes through UpdateRelation's normal
+// read-write-modify cycle, which locks only the one arc being touched, so concurrent
+// UpdateRelation/BumpRelation callers on arcs outside the batch are unaffected and arcs
+// within the batch never contend on a shared lock. With opts.DryRun, no writes are
+// issued and every update is reported as it would succeed.
+func UpdateRelations(updates []RelationUpdate, opts BatchOptions) (BatchResult, error) {
+	var result BatchResult
+
+	for start := 0; start < len(updates); start += opts.batchSize() {
+		end := start + opts.batchSize()
+		if end > len(updates) {
+			end = len(updates)
+		}
+		batch := updates[start:end]
+		offset := start
+
+		var batchResult BatchResult
+		if opts.DryRun {
+			for i := range batch {
+				batchResult.Succeeded = append(batchResult.Succeeded, offset+i)
+			}
+		} else {
+			raw := runBatch(len(batch), func(i int) error {
+				u := batch[i]
+				return UpdateRelation(&u.Tail, &u.Head, &u.Relation, u.From)
+			})
+			for _, i := range raw.Succeeded {
+				batchResult.Succeeded = append(batchResult.Succeeded, offset+i)
+			}
+			for _, f := range raw.Failed {
+				batchResult.Failed = append(batchResult.Failed, BatchFailure{Index: offset + f.Index, Err: f.Err})
+			}
+		}
+
+		result.Succeeded = append(result.Succeeded, batchResult.Succeeded...)
+		result.Failed = append(result.Failed, batchResult.Failed...)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,117 @@
+package graph
+
+import (
+	"sync"
+
+	"github.com/sath33sh/infra/log"
+)
+
+// Arc event, as delivered to a channel sink. It mirrors the shape of an Arc but strips it
+// down to the fields a downstream consumer (activity feed, audit log, Kafka/NATS fan-out)
+// actually cares about.
+type ArcEvent struct {
+	Tail     Node     `json:"tail,omitempty"`     // Tail.
+	Head     Node     `json:"head,omitempty"`     // Head.
+	Relation Relation `json:"relation,omitempty"` // Relation.
+	From     Node     `json:"from,omitempty"`     // Event originator.
+}
+
+// Build the ArcEvent representation of an arc, as recorded on its From field.
+func arcEventFrom(a Arc) ArcEvent {
+	ev := ArcEvent{Tail: a.Tail, Head: a.Head, Relation: a.Relation}
+	if a.From != nil {
+		ev.From = *a.From
+	}
+	return ev
+}
+
+// ArcEventSink receives notifications after this package writes an arc. Implementations
+// must not block for long: they are called synchronously, on the goroutine that made the
+// write, right after the write that triggered them succeeds. CreateArcs and UpdateRelations
+// make their writes from a pool of worker goroutines, so a sink may be called concurrently.
+type ArcEventSink interface {
+	OnArcCreated(Arc)
+	OnRelationChanged(before, after Arc)
+	OnArcDeleted(Arc)
+}
+
+var sinksMu sync.RWMutex
+var sinks = []ArcEventSink{noopSink{}}
+
+// RegisterSink adds sink to the set notified by CreateArc, UpdateRelation, DeleteArc and
+// ArchiveArc. Multiple sinks may be registered; each is called for every event. This
+// package takes no dependency on any particular event bus: register a channel sink (see
+// NewChannelSink) or a custom ArcEventSink to fan out to Kafka, NATS, or anything else.
+func RegisterSink(sink ArcEventSink) {
+	sinksMu.Lock()
+	defer sinksMu.Unlock()
+	sinks = append(sinks, sink)
+}
+
+func notifyArcCreated(a Arc) {
+	sinksMu.RLock()
+	defer sinksMu.RUnlock()
+	for _, s := range sinks {
+		s.OnArcCreated(a)
+	}
+}
+
+func notifyRelationChanged(before, after Arc) {
+	sinksMu.RLock()
+	defer sinksMu.RUnlock()
+	for _, s := range sinks {
+		s.OnRelationChanged(before, after)
+	}
+}
+
+func notifyArcDeleted(a Arc) {
+	sinksMu.RLock()
+	defer sinksMu.RUnlock()
+	for _, s := range sinks {
+		s.OnArcDeleted(a)
+	}
+}
+
+// Default sink: does nothing. Keeps the sink list non-empty so notify* never special-cases
+// an empty registry.
+type noopSink struct{}
+
+func (noopSink) OnArcCreated(Arc)                    {}
+func (noopSink) OnRelationChanged(before, after Arc) {}
+func (noopSink) OnArcDeleted(Arc)                    {}
+
+// Channel-based sink: converts every event into an ArcEvent and pushes it onto a channel,
+// so a downstream service can range over it without implementing ArcEventSink itself. When
+// the channel is full the event is dropped rather than blocked on, since sinks run
+// synchronously on the writing goroutine and must not stall a write.
+type channelSink struct {
+	ch chan ArcEvent
+}
+
+// NewChannelSink creates a sink backed by a channel of capacity buf and returns both the
+// sink (to pass to RegisterSink) and the receive-only channel to consume events from.
+func NewChannelSink(buf int) (ArcEventSink, <-chan ArcEvent) {
+	ch := make(chan ArcEvent, buf)
+	return &channelSink{ch: ch}, ch
+}
+
+func (s *channelSink) send(ev ArcEvent) {
+	select {
+	case s.ch <- ev:
+	default:
+		log.Errorf("graph: channel sink buffer full, dropping arc event for %s:%s > %s:%s",
+			ev.Tail.Type, ev.Tail.Id, ev.Head.Type, ev.Head.Id)
+	}
+}
+
+func (s *channelSink) OnArcCreated(a Arc) {
+	s.send(arcEventFrom(a))
+}
+
+func (s *channelSink) OnRelationChanged(before, after Arc) {
+	s.send(arcEventFrom(after))
+}
+
+func (s *channelSink) OnArcDeleted(a Arc) {
+	s.send(arcEventFrom(a))
+}
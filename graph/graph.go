@@ -3,6 +3,7 @@
 package graph
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/sath33sh/infra/db"
 	"github.com/sath33sh/infra/log"
@@ -45,12 +46,52 @@ const (
 	BLOCK                 = "block"    // Block.
 )
 
+// Value of a single relationship verb on an arc.
+type RelationValue struct {
+	Active    bool      `json:"active"`              // Whether the verb currently holds between tail and head.
+	Weight    float64   `json:"weight,omitempty"`    // Strength of the relationship. Legacy bool arcs decode as weight 1.0.
+	UpdatedAt time.Time `json:"updatedAt,omitempty"` // Last time this verb's weight was touched. Used to decay weight over time.
+}
+
 // Relationship map.
-type Relation map[RelationVerb]bool
+type Relation map[RelationVerb]RelationValue
+
+// UnmarshalJSON decodes a relation map, accepting both the current object form
+// (relation.<verb> == {"active":true,"weight":1.5,...}) and the legacy boolean form
+// (relation.<verb> == true) written by arcs created before weighted relations existed.
+// A legacy `true` decodes as RelationValue{Active: true, Weight: 1.0}; legacy `false`
+// entries are dropped, matching the old convention that only true verbs were kept.
+func (r *Relation) UnmarshalJSON(data []byte) error {
+	var raw map[RelationVerb]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	out := make(Relation, len(raw))
+	for verb, msg := range raw {
+		var legacy bool
+		if err := json.Unmarshal(msg, &legacy); err == nil {
+			if legacy {
+				out[verb] = RelationValue{Active: true, Weight: 1.0}
+			}
+			continue
+		}
+
+		var val RelationValue
+		if err := json.Unmarshal(msg, &val); err != nil {
+			return err
+		}
+		out[verb] = val
+	}
+
+	*r = out
+	return nil
+}
 
 // Options.
 type Options struct {
-	Ord int `json:"ord,omitempty"` // Ordinal. Can be used to order arcs.
+	Ord       int        `json:"ord,omitempty"`       // Ordinal. Can be used to order arcs.
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"` // Time after which the arc stops appearing in queries. Nil means it never expires.
 }
 
 // Arc object type.
@@ -58,12 +99,50 @@ const OBJ_ARC db.ObjType = "arc"
 
 // Arc.
 type Arc struct {
-	Type      db.ObjType `json:"type"`      // Document type: "arc"
-	Tail      Node       `json:"tail"`      // Tail of the arc.
-	Head      Node       `json:"head"`      // Head of the arc.
-	Relation  Relation   `json:"relation"`  // Relationship map.
-	Options   Options    `json:"options"`   // Options.
-	CreatedAt time.Time  `json:"createdAt"` // Arc creation time.
+	Type       db.ObjType `json:"type"`                 // Document type: "arc"
+	Tail       Node       `json:"tail"`                 // Tail of the arc.
+	Head       Node       `json:"head"`                 // Head of the arc.
+	Relation   Relation   `json:"relation"`             // Relationship map.
+	Options    Options    `json:"options"`              // Options.
+	CreatedAt  time.Time  `json:"createdAt"`            // Arc creation time.
+	ArchivedAt *time.Time `json:"archivedAt,omitempty"` // Time the arc was archived. Nil means it is active.
+	From       *Node      `json:"from,omitempty"`       // Originator of the most recent change to this arc, if known. Feeds ArcEvent.From for audit trails.
+}
+
+// Options controlling which arcs are visible to a query.
+type QueryOptions struct {
+	IncludeArchived bool // Include archived arcs in results. Default is to hide them.
+}
+
+// N1QL predicate that hides archived and expired arcs unless opts opts out of it.
+func (opts QueryOptions) filterClause() string {
+	return opts.filterClauseAliased("")
+}
+
+// Same as filterClause, but with fields qualified by alias (needed once the arc bucket is
+// aliased, as CountByVerb does for its UNNEST). Pass "" for an unaliased query.
+func (opts QueryOptions) filterClauseAliased(alias string) string {
+	prefix := alias
+	if prefix != "" {
+		prefix += "."
+	}
+	// Compare as millis rather than as strings: a raw string comparison against NOW_STR()
+	// only orders correctly when every stored ExpiresAt marshals with the same UTC/"Z"
+	// offset NOW_STR() uses, and STR_TO_MILLIS tolerates whatever RFC3339 offset was stored.
+	clause := fmt.Sprintf(" AND (%soptions.expiresAt IS MISSING OR STR_TO_MILLIS(%soptions.expiresAt) > NOW_MILLIS())", prefix, prefix)
+	if !opts.IncludeArchived {
+		clause += fmt.Sprintf(" AND %sarchivedAt IS MISSING", prefix)
+	}
+	return clause
+}
+
+// N1QL predicate testing whether expr (e.g. "relation.follow" or an UNNESTed alias like
+// "pair.val") is active, accepting both the current object form (expr.active=true) and the
+// legacy boolean form (expr=true) written before weighted relations existed. Every query
+// that tests a verb's active state must go through this rather than expr.active=true alone,
+// or un-migrated legacy arcs silently drop out of the results.
+func activeClause(expr string) string {
+	return fmt.Sprintf("(%s=true OR %s.active=true)", expr, expr)
 }
 
 // Allocate a new node ID.
@@ -87,35 +166,9 @@ func (a *Arc) SetType() {
 	a.Type = OBJ_ARC
 }
 
-/*
-// Arc event.
-type ArcEvent struct {
-	Tail     Node     `json:"tail,omitempty"`     // Tail.
-	Head     Node     `json:"head,omitempty"`     // Head.
-	Relation Relation `json:"relation,omitempty"` // Relation.
-	From     Node     `json:"from,omitempty"`     // Event originator.
-}
-
-// Event interface method.
-func (ev *ArcEvent) EncodeEventPayload() (event.CategoryIndex, *event.Payload, error) {
-	p := &event.Payload{
-		Key:  string(ev.Head.Id),
-		Type: OBJ_ARC,
-	}
-
-	// Encode data.
-	var err error
-	if p.Data, err = json.Marshal(ev); err != nil {
-		log.Errorf("Event payload JSON marshal error: %v", err)
-		return event.GRAPH_CATEGORY, nil, util.ERR_JSON_DECODE
-	}
-
-	return event.GRAPH_CATEGORY, p, nil
-}
-*/
-
-// Create arc between two nodes.
-func CreateArc(tail, head *Node, r *Relation, opts Options) (err error) {
+// Create arc between two nodes. from, if non-nil, is recorded as the arc's originator and
+// forwarded to registered event sinks; pass nil when the caller has no notion of one.
+func CreateArc(tail, head *Node, r *Relation, opts Options, from *Node) (err error) {
 	var a Arc
 
 	a.Tail = *tail
@@ -123,11 +176,14 @@ func CreateArc(tail, head *Node, r *Relation, opts Options) (err error) {
 	a.Relation = *r
 	a.Options = opts
 	a.CreatedAt = time.Now()
+	a.From = from
 
 	if err = db.Upsert(&a, 0); err != nil {
 		return err
 	}
 
+	notifyArcCreated(a)
+
 	return err
 }
 
@@ -141,8 +197,10 @@ func GetRelation(tail, head *Node) (r Relation, err error) {
 	return r, err
 }
 
-// Update relationship between two actors.
-func UpdateRelation(tail, head *Node, r *Relation) (err error) {
+// Update relationship between two actors. from, if non-nil, is recorded as the change's
+// originator and forwarded to registered event sinks; pass nil when the caller has no
+// notion of one.
+func UpdateRelation(tail, head *Node, r *Relation, from *Node) (err error) {
 	// Perform RWM.
 
 	// Read.
@@ -151,17 +209,30 @@ func UpdateRelation(tail, head *Node, r *Relation) (err error) {
 	if lock, err = db.GetLock(&la); err != nil {
 		return err
 	}
+	before := la
+	before.Relation = make(Relation, len(la.Relation))
+	for verb, val := range la.Relation {
+		before.Relation[verb] = val
+	}
 
 	// Update.
 	la.Tail = *tail
 	la.Head = *head
+	la.From = from
 
-	// Merge in new relation.
-	for key, val := range *r {
-		if val {
-			la.Relation[key] = val
+	// Merge in new relation. A verb with Active=false is a request to remove that
+	// verb rather than to store an inactive value.
+	if la.Relation == nil {
+		la.Relation = Relation{}
+	}
+	for verb, val := range *r {
+		if val.Active {
+			if val.UpdatedAt.IsZero() {
+				val.UpdatedAt = time.Now()
+			}
+			la.Relation[verb] = val
 		} else {
-			delete(la.Relation, key)
+			delete(la.Relation, verb)
 		}
 	}
 
@@ -170,6 +241,8 @@ func UpdateRelation(tail, head *Node, r *Relation) (err error) {
 		return err
 	}
 
+	notifyRelationChanged(before, la)
+
 	return err
 }
 
@@ -192,46 +265,40 @@ func (qr *NodeQueryResult) GetRowPtr(index int) interface{} {
 }
 
 // Query list of tail actors given a head actor ID and relation.
-func (qr *NodeQueryResult) QueryTails(head *Node, r RelationVerb, limit, offset int) (size int, err error) {
-	// N1QL query statement.
-	queryStmt := fmt.Sprintf("SELECT tail.* FROM `%s` WHERE type=\"%s\" AND head.type=\"%s\" AND head.id=\"%s\" AND relation.%s=true ORDER BY tail.name",
-		db.BucketName(db.DEFAULT_BUCKET), OBJ_ARC, head.Type, head.Id, r)
-
-	size, err = db.ExecPagedQuery(db.DEFAULT_BUCKET, qr, queryStmt, limit, offset)
+// This is a thin wrapper over the generic QueryTails for callers happy with bare Nodes.
+func (qr *NodeQueryResult) QueryTails(head *Node, r RelationVerb, limit, offset int, opts QueryOptions) (size int, err error) {
+	generic, err := QueryTails[Node](head, r, limit, offset, opts)
 	if err != nil {
-		return size, err
+		return 0, err
 	}
 
-	qr.Results = qr.Results[:size]
-	qr.PrevOffset = fmt.Sprintf("%d", offset)
-	qr.NextOffset = fmt.Sprintf("%d", offset+size)
+	qr.Results = generic.Results
+	qr.PrevOffset = generic.PrevOffset
+	qr.NextOffset = generic.NextOffset
 
-	return size, err
+	return len(qr.Results), nil
 }
 
 // Query list of head actors given a tail actor ID and relation.
-func (qr *NodeQueryResult) QueryHeads(tail *Node, r RelationVerb, limit, offset int) (size int, err error) {
-	// N1QL query statement.
-	queryStmt := fmt.Sprintf("SELECT head.* FROM `%s` WHERE type=\"%s\" AND tail.type=\"%s\" AND tail.id=\"%s\" AND relation.%s=true ORDER BY head.name",
-		db.BucketName(db.DEFAULT_BUCKET), OBJ_ARC, tail.Type, tail.Id, r)
-
-	size, err = db.ExecPagedQuery(db.DEFAULT_BUCKET, qr, queryStmt, limit, offset)
+// This is a thin wrapper over the generic QueryHeads for callers happy with bare Nodes.
+func (qr *NodeQueryResult) QueryHeads(tail *Node, r RelationVerb, limit, offset int, opts QueryOptions) (size int, err error) {
+	generic, err := QueryHeads[Node](tail, r, limit, offset, opts)
 	if err != nil {
-		return size, err
+		return 0, err
 	}
 
-	qr.Results = qr.Results[:size]
-	qr.PrevOffset = fmt.Sprintf("%d", offset)
-	qr.NextOffset = fmt.Sprintf("%d", offset+size)
+	qr.Results = generic.Results
+	qr.PrevOffset = generic.PrevOffset
+	qr.NextOffset = generic.NextOffset
 
-	return size, err
+	return len(qr.Results), nil
 }
 
 // Count tails to a head.
-func Indegree(head *Node, r RelationVerb) (count int, err error) {
+func Indegree(head *Node, r RelationVerb, opts QueryOptions) (count int, err error) {
 	// N1QL query statement.
-	queryStmt := fmt.Sprintf("SELECT COUNT(*) FROM `%s` WHERE `type`=\"%s\" AND head.type=\"%s\" AND head.id=\"%s\" AND relation.%s=true",
-		db.BucketName(db.DEFAULT_BUCKET), OBJ_ARC, head.Type, head.Id, r)
+	queryStmt := fmt.Sprintf("SELECT COUNT(*) FROM `%s` WHERE `type`=\"%s\" AND head.type=\"%s\" AND head.id=\"%s\" AND %s%s",
+		db.BucketName(db.DEFAULT_BUCKET), OBJ_ARC, head.Type, head.Id, activeClause(fmt.Sprintf("relation.%s", r)), opts.filterClause())
 
 	count, err = db.ExecCount(db.DEFAULT_BUCKET, queryStmt)
 	if err != nil {
@@ -243,10 +310,10 @@ func Indegree(head *Node, r RelationVerb) (count int, err error) {
 }
 
 // Count heads from a tail.
-func Outdegree(tail *Node, r RelationVerb) (count int, err error) {
+func Outdegree(tail *Node, r RelationVerb, opts QueryOptions) (count int, err error) {
 	// N1QL query statement.
-	queryStmt := fmt.Sprintf("SELECT COUNT(*) FROM `%s` WHERE `type`=\"%s\" AND tail.type=\"%s\" AND tail.id=\"%s\" AND relation.%s=true",
-		db.BucketName(db.DEFAULT_BUCKET), OBJ_ARC, tail.Type, tail.Id, r)
+	queryStmt := fmt.Sprintf("SELECT COUNT(*) FROM `%s` WHERE `type`=\"%s\" AND tail.type=\"%s\" AND tail.id=\"%s\" AND %s%s",
+		db.BucketName(db.DEFAULT_BUCKET), OBJ_ARC, tail.Type, tail.Id, activeClause(fmt.Sprintf("relation.%s", r)), opts.filterClause())
 
 	count, err = db.ExecCount(db.DEFAULT_BUCKET, queryStmt)
 	if err != nil {
@@ -258,12 +325,12 @@ func Outdegree(tail *Node, r RelationVerb) (count int, err error) {
 }
 
 // Iterator for tails.
-func ForEachTail(head *Node, r RelationVerb, cb func(*Node)) {
+func ForEachTail(head *Node, r RelationVerb, cb func(*Node), opts QueryOptions) {
 	var err error
 
 	// N1QL query statement.
-	queryStmt := fmt.Sprintf("SELECT tail.* FROM `%s` WHERE type=\"%s\" AND head.type=\"%s\" AND head.id=\"%s\" AND relation.%s=true",
-		db.BucketName(db.DEFAULT_BUCKET), OBJ_ARC, head.Type, head.Id, r)
+	queryStmt := fmt.Sprintf("SELECT tail.* FROM `%s` WHERE type=\"%s\" AND head.type=\"%s\" AND head.id=\"%s\" AND %s%s",
+		db.BucketName(db.DEFAULT_BUCKET), OBJ_ARC, head.Type, head.Id, activeClause(fmt.Sprintf("relation.%s", r)), opts.filterClause())
 
 	size := db.QUERY_LIMIT_MAX
 	offset := 0
@@ -282,12 +349,12 @@ func ForEachTail(head *Node, r RelationVerb, cb func(*Node)) {
 }
 
 // Iterator for heads.
-func ForEachHead(tail *Node, r RelationVerb, cb func(*Node)) {
+func ForEachHead(tail *Node, r RelationVerb, cb func(*Node), opts QueryOptions) {
 	var err error
 
 	// N1QL query statement.
-	queryStmt := fmt.Sprintf("SELECT head.* FROM `%s` WHERE type=\"%s\" AND tail.type=\"%s\" AND tail.id=\"%s\" AND relation.%s=true",
-		db.BucketName(db.DEFAULT_BUCKET), OBJ_ARC, tail.Type, tail.Id, r)
+	queryStmt := fmt.Sprintf("SELECT head.* FROM `%s` WHERE type=\"%s\" AND tail.type=\"%s\" AND tail.id=\"%s\" AND %s%s",
+		db.BucketName(db.DEFAULT_BUCKET), OBJ_ARC, tail.Type, tail.Id, activeClause(fmt.Sprintf("relation.%s", r)), opts.filterClause())
 
 	size := db.QUERY_LIMIT_MAX
 	offset := 0
@@ -0,0 +1,70 @@
+package graph
+
+import (
+	"fmt"
+
+	"github.com/sath33sh/infra/db"
+)
+
+// Query result which contains a list of items decoded from an arc's tail or head side.
+// T is typically an embedding struct such as User or Post rather than the bare Node, so
+// callers can decode the neighbor directly instead of following up with a db.Get per row.
+type QueryResult[T any] struct {
+	Results    []T    `json:"results,omitempty"` // Results is list of decoded neighbors.
+	NextOffset string `json:"nextOffset"`        // Next offset.
+	PrevOffset string `json:"prevOffset"`        // Previous offset.
+}
+
+func (qr *QueryResult[T]) GetRowPtr(index int) interface{} {
+	if index < len(qr.Results) {
+		return &qr.Results[index]
+	} else if index == len(qr.Results) {
+		var zero T
+		qr.Results = append(qr.Results, zero)
+		return &qr.Results[index]
+	} else {
+		return nil
+	}
+}
+
+// QueryTails is the generic form of NodeQueryResult.QueryTails: it decodes each tail
+// directly into T (e.g. User, Post) instead of the bare Node, so callers who need fields
+// beyond Type/Id/Name/Photo don't have to follow up with a db.Get per row.
+func QueryTails[T any](head *Node, r RelationVerb, limit, offset int, opts QueryOptions) (*QueryResult[T], error) {
+	// N1QL query statement.
+	queryStmt := fmt.Sprintf("SELECT tail.* FROM `%s` WHERE type=\"%s\" AND head.type=\"%s\" AND head.id=\"%s\" AND %s%s ORDER BY tail.name",
+		db.BucketName(db.DEFAULT_BUCKET), OBJ_ARC, head.Type, head.Id, activeClause(fmt.Sprintf("relation.%s", r)), opts.filterClause())
+
+	qr := &QueryResult[T]{}
+	size, err := db.ExecPagedQuery(db.DEFAULT_BUCKET, qr, queryStmt, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	qr.Results = qr.Results[:size]
+	qr.PrevOffset = fmt.Sprintf("%d", offset)
+	qr.NextOffset = fmt.Sprintf("%d", offset+size)
+
+	return qr, nil
+}
+
+// QueryHeads is the generic form of NodeQueryResult.QueryHeads: it decodes each head
+// directly into T (e.g. User, Post) instead of the bare Node, so callers who need fields
+// beyond Type/Id/Name/Photo don't have to follow up with a db.Get per row.
+func QueryHeads[T any](tail *Node, r RelationVerb, limit, offset int, opts QueryOptions) (*QueryResult[T], error) {
+	// N1QL query statement.
+	queryStmt := fmt.Sprintf("SELECT head.* FROM `%s` WHERE type=\"%s\" AND tail.type=\"%s\" AND tail.id=\"%s\" AND %s%s ORDER BY head.name",
+		db.BucketName(db.DEFAULT_BUCKET), OBJ_ARC, tail.Type, tail.Id, activeClause(fmt.Sprintf("relation.%s", r)), opts.filterClause())
+
+	qr := &QueryResult[T]{}
+	size, err := db.ExecPagedQuery(db.DEFAULT_BUCKET, qr, queryStmt, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	qr.Results = qr.Results[:size]
+	qr.PrevOffset = fmt.Sprintf("%d", offset)
+	qr.NextOffset = fmt.Sprintf("%d", offset+size)
+
+	return qr, nil
+}
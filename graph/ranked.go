@@ -0,0 +1,143 @@
+package graph
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/sath33sh/infra/db"
+)
+
+// Half-life used to decay a relation's weight when it is bumped. A weight left untouched
+// loses half its value every relationDecayHalfLife, so recent activity dominates ranking.
+const relationDecayHalfLife = 30 * 24 * time.Hour
+
+// Node paired with the weight of the relation that produced it.
+type NodeWeight struct {
+	Node
+	Weight float64 `json:"weight"`
+}
+
+// Query result which contains a list of nodes ranked by relation weight.
+type RankedQueryResult struct {
+	Results    []NodeWeight `json:"results,omitempty"` // Results is list of nodes, highest weight first.
+	NextOffset string       `json:"nextOffset"`        // Next offset.
+	PrevOffset string       `json:"prevOffset"`        // Previous offset.
+}
+
+func (qr *RankedQueryResult) GetRowPtr(index int) interface{} {
+	if index < len(qr.Results) {
+		return &qr.Results[index]
+	} else if index == len(qr.Results) {
+		qr.Results = append(qr.Results, NodeWeight{})
+		return &qr.Results[index]
+	} else {
+		return nil
+	}
+}
+
+// Query list of tail actors given a head actor ID and relation, ranked by weight descending.
+// Only arcs whose verb weight is at least minWeight are returned. opts controls whether
+// archived or expired arcs are eligible, same as every other query in this package.
+func (qr *RankedQueryResult) QueryTailsRanked(head *Node, r RelationVerb, minWeight float64, limit, offset int, opts QueryOptions) (size int, err error) {
+	// N1QL query statement. IFMISSINGORNULL defaults weight to 1.0 for legacy bool-encoded
+	// arcs, which have no weight field, matching the value UnmarshalJSON assigns them.
+	weightExpr := fmt.Sprintf("IFMISSINGORNULL(relation.%s.weight, 1.0)", r)
+	queryStmt := fmt.Sprintf("SELECT tail.*, %s AS weight FROM `%s` WHERE type=\"%s\" AND head.type=\"%s\" AND head.id=\"%s\" "+
+		"AND %s AND %s>=%v%s ORDER BY %s DESC",
+		weightExpr, db.BucketName(db.DEFAULT_BUCKET), OBJ_ARC, head.Type, head.Id,
+		activeClause(fmt.Sprintf("relation.%s", r)), weightExpr, minWeight, opts.filterClause(), weightExpr)
+
+	size, err = db.ExecPagedQuery(db.DEFAULT_BUCKET, qr, queryStmt, limit, offset)
+	if err != nil {
+		return size, err
+	}
+
+	qr.Results = qr.Results[:size]
+	qr.PrevOffset = fmt.Sprintf("%d", offset)
+	qr.NextOffset = fmt.Sprintf("%d", offset+size)
+
+	return size, err
+}
+
+// Query list of head actors given a tail actor ID and relation, ranked by weight descending.
+// Only arcs whose verb weight is at least minWeight are returned. opts controls whether
+// archived or expired arcs are eligible, same as every other query in this package.
+func (qr *RankedQueryResult) QueryHeadsRanked(tail *Node, r RelationVerb, minWeight float64, limit, offset int, opts QueryOptions) (size int, err error) {
+	// N1QL query statement. IFMISSINGORNULL defaults weight to 1.0 for legacy bool-encoded
+	// arcs, which have no weight field, matching the value UnmarshalJSON assigns them.
+	weightExpr := fmt.Sprintf("IFMISSINGORNULL(relation.%s.weight, 1.0)", r)
+	queryStmt := fmt.Sprintf("SELECT head.*, %s AS weight FROM `%s` WHERE type=\"%s\" AND tail.type=\"%s\" AND tail.id=\"%s\" "+
+		"AND %s AND %s>=%v%s ORDER BY %s DESC",
+		weightExpr, db.BucketName(db.DEFAULT_BUCKET), OBJ_ARC, tail.Type, tail.Id,
+		activeClause(fmt.Sprintf("relation.%s", r)), weightExpr, minWeight, opts.filterClause(), weightExpr)
+
+	size, err = db.ExecPagedQuery(db.DEFAULT_BUCKET, qr, queryStmt, limit, offset)
+	if err != nil {
+		return size, err
+	}
+
+	qr.Results = qr.Results[:size]
+	qr.PrevOffset = fmt.Sprintf("%d", offset)
+	qr.NextOffset = fmt.Sprintf("%d", offset+size)
+
+	return size, err
+}
+
+// Apply exponential time decay to weight, as of now, given it was last touched at updatedAt.
+func decayWeight(weight float64, updatedAt, now time.Time) float64 {
+	if updatedAt.IsZero() || weight == 0 {
+		return weight
+	}
+
+	elapsed := now.Sub(updatedAt)
+	if elapsed <= 0 {
+		return weight
+	}
+
+	return weight * math.Exp(-math.Ln2*float64(elapsed)/float64(relationDecayHalfLife))
+}
+
+// BumpRelation increases the weight of verb between tail and head by delta, decaying the
+// existing weight for time elapsed since it was last touched first. This is the primitive
+// behind view/like/follow ranking and affinity feeds: each activity event calls BumpRelation
+// instead of maintaining a parallel counter next to the arc.
+func BumpRelation(tail, head *Node, verb RelationVerb, delta float64) (err error) {
+	// Perform RWM.
+
+	// Read.
+	var lock db.Lock
+	la := Arc{Tail: Node{Type: tail.Type, Id: tail.Id}, Head: Node{Type: head.Type, Id: head.Id}}
+	if lock, err = db.GetLock(&la); err != nil {
+		return err
+	}
+	before := la
+	before.Relation = make(Relation, len(la.Relation))
+	for v, val := range la.Relation {
+		before.Relation[v] = val
+	}
+
+	// Update.
+	la.Tail = *tail
+	la.Head = *head
+	la.From = nil
+	if la.Relation == nil {
+		la.Relation = Relation{}
+	}
+
+	now := time.Now()
+	val := la.Relation[verb]
+	val.Active = true
+	val.Weight = decayWeight(val.Weight, val.UpdatedAt, now) + delta
+	val.UpdatedAt = now
+	la.Relation[verb] = val
+
+	// Write.
+	if err = db.WriteUnlock(&la, lock, 0); err != nil {
+		return err
+	}
+
+	notifyRelationChanged(before, la)
+
+	return err
+}
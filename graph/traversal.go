@@ -0,0 +1,244 @@
+package graph
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sath33sh/infra/db"
+)
+
+// Traversal direction relative to the starting node.
+type Direction int
+
+// Supported traversal directions.
+const (
+	Outgoing Direction = iota // Follow arcs where the current node is the tail.
+	Incoming                  // Follow arcs where the current node is the head.
+	Both                      // Follow arcs in either direction.
+)
+
+// Traversal specification.
+type TraversalSpec struct {
+	Direction Direction                   // Direction to walk arcs in.
+	Verbs     []RelationVerb              // Allow-list of relation verbs to follow.
+	MaxDepth  int                         // Maximum BFS depth to explore.
+	Filter    func(*Node) bool            // Optional per-node filter. Node is skipped (not expanded) when it returns false.
+	Visit     func(node *Node, depth int) // Optional callback invoked in BFS order as nodes are discovered.
+	Options   QueryOptions                // Archived/expired arc visibility. Default hides them, same as every other query in this package.
+}
+
+// Traversal result.
+type TraversalResult struct {
+	Nodes  []Node         `json:"nodes,omitempty"`  // Nodes discovered, in BFS order.
+	Depths map[string]int `json:"depths,omitempty"` // Depth of each node, keyed by "Type:Id".
+}
+
+// Frontier entry used while walking the graph breadth-first.
+type frontierEntry struct {
+	node  Node
+	depth int
+}
+
+// Row shape used to decode arcs discovered while walking the graph.
+type arcRow struct {
+	Tail     Node     `json:"tail"`
+	Head     Node     `json:"head"`
+	Relation Relation `json:"relation"`
+}
+
+type arcRowResult struct {
+	Results []arcRow
+}
+
+func (qr *arcRowResult) GetRowPtr(index int) interface{} {
+	if index < len(qr.Results) {
+		return &qr.Results[index]
+	} else if index == len(qr.Results) {
+		qr.Results = append(qr.Results, arcRow{})
+		return &qr.Results[index]
+	} else {
+		return nil
+	}
+}
+
+// Key used to dedupe nodes while walking the graph.
+func nodeKey(n *Node) string {
+	return fmt.Sprintf("%s:%s", n.Type, n.Id)
+}
+
+// Build the OR'd verb predicate, e.g. ((relation.follow=true OR relation.follow.active=true)
+// OR (relation.friend=true OR relation.friend.active=true)).
+func verbPredicate(verbs []RelationVerb) string {
+	parts := make([]string, len(verbs))
+	for i, v := range verbs {
+		parts[i] = activeClause(fmt.Sprintf("relation.%s", v))
+	}
+	return "(" + strings.Join(parts, " OR ") + ")"
+}
+
+// Fetch the arcs adjacent to node in the requested direction, matching the verb allow-list.
+// Issues exactly one N1QL query (paged internally) rather than one call per verb. An empty
+// verbs matches nothing, consistent with it being an allow-list rather than a wildcard.
+func adjacentArcs(node *Node, dir Direction, verbs []RelationVerb, opts QueryOptions) ([]arcRow, error) {
+	if len(verbs) == 0 {
+		return nil, nil
+	}
+
+	bucket := db.BucketName(db.DEFAULT_BUCKET)
+	verbClause := verbPredicate(verbs)
+	filterClause := opts.filterClause()
+
+	var queryStmt string
+	switch dir {
+	case Outgoing:
+		queryStmt = fmt.Sprintf("SELECT tail, head, relation FROM `%s` WHERE type=\"%s\" AND tail.type=\"%s\" AND tail.id=\"%s\" AND %s%s",
+			bucket, OBJ_ARC, node.Type, node.Id, verbClause, filterClause)
+	case Incoming:
+		queryStmt = fmt.Sprintf("SELECT tail, head, relation FROM `%s` WHERE type=\"%s\" AND head.type=\"%s\" AND head.id=\"%s\" AND %s%s",
+			bucket, OBJ_ARC, node.Type, node.Id, verbClause, filterClause)
+	default: // Both
+		queryStmt = fmt.Sprintf("SELECT tail, head, relation FROM `%s` WHERE type=\"%s\" AND tail.type=\"%s\" AND tail.id=\"%s\" AND %s%s "+
+			"UNION ALL SELECT tail, head, relation FROM `%s` WHERE type=\"%s\" AND head.type=\"%s\" AND head.id=\"%s\" AND %s%s",
+			bucket, OBJ_ARC, node.Type, node.Id, verbClause, filterClause, bucket, OBJ_ARC, node.Type, node.Id, verbClause, filterClause)
+	}
+
+	var rows []arcRow
+	size := db.QUERY_LIMIT_MAX
+	offset := 0
+	for size == db.QUERY_LIMIT_MAX {
+		var qr arcRowResult
+		var err error
+		size, err = db.ExecPagedQuery(db.DEFAULT_BUCKET, &qr, queryStmt, size, offset)
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, qr.Results[:size]...)
+		offset += size
+	}
+
+	return rows, nil
+}
+
+// Return the neighbor node reached by crossing arc a, walking in direction dir from node.
+func neighborOf(a arcRow, node *Node, dir Direction) Node {
+	if dir == Incoming {
+		return a.Tail
+	}
+	if dir == Both && nodeKey(&a.Tail) != nodeKey(node) {
+		return a.Tail
+	}
+	return a.Head
+}
+
+// Traverse walks the graph breadth-first from start, following arcs whose verb is in
+// spec.Verbs, up to spec.MaxDepth hops. For each frontier node it issues exactly one N1QL
+// query (see adjacentArcs) rather than one query per node per verb.
+func Traverse(start *Node, spec TraversalSpec) (*TraversalResult, error) {
+	result := &TraversalResult{Depths: map[string]int{}}
+
+	visited := map[string]struct{}{nodeKey(start): {}}
+	result.Nodes = append(result.Nodes, *start)
+	result.Depths[nodeKey(start)] = 0
+	if spec.Visit != nil {
+		spec.Visit(start, 0)
+	}
+
+	frontier := []frontierEntry{{node: *start, depth: 0}}
+	for len(frontier) > 0 {
+		var next []frontierEntry
+
+		for _, entry := range frontier {
+			if entry.depth >= spec.MaxDepth {
+				continue
+			}
+
+			arcs, err := adjacentArcs(&entry.node, spec.Direction, spec.Verbs, spec.Options)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, a := range arcs {
+				neighbor := neighborOf(a, &entry.node, spec.Direction)
+				key := nodeKey(&neighbor)
+				if _, ok := visited[key]; ok {
+					continue
+				}
+				if spec.Filter != nil && !spec.Filter(&neighbor) {
+					continue
+				}
+
+				visited[key] = struct{}{}
+				depth := entry.depth + 1
+				result.Nodes = append(result.Nodes, neighbor)
+				result.Depths[key] = depth
+				if spec.Visit != nil {
+					spec.Visit(&neighbor, depth)
+				}
+				next = append(next, frontierEntry{node: neighbor, depth: depth})
+			}
+		}
+
+		frontier = next
+	}
+
+	return result, nil
+}
+
+// ShortestPath finds the shortest sequence of arcs connecting src to dst, following only the
+// given verbs, and returns the arcs in order from src to dst. It returns a nil slice with no
+// error if dst is unreachable from src. opts controls whether archived or expired arcs are
+// eligible to be crossed, same as every other query in this package.
+func ShortestPath(src, dst *Node, verbs []RelationVerb, opts QueryOptions) ([]Arc, error) {
+	srcKey, dstKey := nodeKey(src), nodeKey(dst)
+	if srcKey == dstKey {
+		return nil, nil
+	}
+
+	type parentEntry struct {
+		node Node
+		arc  arcRow
+	}
+
+	visited := map[string]struct{}{srcKey: {}}
+	parent := map[string]parentEntry{}
+	frontier := []Node{*src}
+
+	for len(frontier) > 0 {
+		var next []Node
+
+		for _, node := range frontier {
+			arcs, err := adjacentArcs(&node, Both, verbs, opts)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, a := range arcs {
+				neighbor := neighborOf(a, &node, Both)
+				key := nodeKey(&neighbor)
+				if _, ok := visited[key]; ok {
+					continue
+				}
+
+				visited[key] = struct{}{}
+				parent[key] = parentEntry{node: node, arc: a}
+
+				if key == dstKey {
+					// Reconstruct the path by walking parent pointers back to src.
+					var path []Arc
+					for k := dstKey; k != srcKey; {
+						pe := parent[k]
+						path = append([]Arc{{Tail: pe.arc.Tail, Head: pe.arc.Head, Relation: pe.arc.Relation}}, path...)
+						k = nodeKey(&pe.node)
+					}
+					return path, nil
+				}
+
+				next = append(next, neighbor)
+			}
+		}
+
+		frontier = next
+	}
+
+	return nil, nil
+}